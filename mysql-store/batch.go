@@ -0,0 +1,165 @@
+package mysql_store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultBatchSize caps the number of rows sent in a single multi-value
+// INSERT, keeping the statement well under MySQL's max_allowed_packet and
+// max_prepared_stmt_count limits.
+const defaultBatchSize = 500
+
+// batchRow is a single (hash, range01, value) tuple destined for one of the
+// index or chunk tables, which all share this column layout.
+type batchRow struct {
+	Hash    []byte
+	Range01 []byte
+	Value   []byte
+}
+
+// batchBounds splits n rows into [start, end) boundaries of at most
+// defaultBatchSize each, shared by batchInsert and batchDelete's chunking
+// loops.
+func batchBounds(n int) [][2]int {
+	var bounds [][2]int
+	for start := 0; start < n; start += defaultBatchSize {
+		end := start + defaultBatchSize
+		if end > n {
+			end = n
+		}
+		bounds = append(bounds, [2]int{start, end})
+	}
+	return bounds
+}
+
+// batchInsert writes rows to table on db in chunks of defaultBatchSize, each
+// chunk executed as a single multi-value `INSERT ... ON DUPLICATE KEY UPDATE`
+// inside its own transaction so a failure only rolls back its own chunk.
+// table is validated against the whitelist first, since it's concatenated
+// directly into the query.
+func (s *server) batchInsert(ctx context.Context, db *sql.DB, table string, rows []batchRow) error {
+	ok, err := s.allowedTable(ctx, table)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("unknown table %q", table)
+	}
+
+	for _, b := range batchBounds(len(rows)) {
+		if err := batchInsertChunk(ctx, db, table, rows[b[0]:b[1]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func batchInsertChunk(ctx context.Context, db *sql.DB, table string, rows []batchRow) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	placeholders := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*3)
+	for i, row := range rows {
+		placeholders[i] = "(?, ?, ?)"
+		args = append(args, row.Hash, row.Range01, row.Value)
+	}
+
+	query := "INSERT INTO " + table + " (hash, range01, value) VALUES " +
+		strings.Join(placeholders, ",") + " ON DUPLICATE KEY UPDATE value=VALUES(value)"
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// batchInsertGrouped writes rows bucketed by destination shard and table,
+// one goroutine per shard, so a sharded write fans out concurrently instead
+// of waiting on each shard in turn.
+func (s *server) batchInsertGrouped(ctx context.Context, byDBTable map[*sql.DB]map[string][]batchRow) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for db, byTable := range byDBTable {
+		wg.Add(1)
+		go func(db *sql.DB, byTable map[string][]batchRow) {
+			defer wg.Done()
+			for table, rows := range byTable {
+				if err := s.batchInsert(ctx, db, table, rows); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(db, byTable)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// batchDelete removes rows matching the given hash/range01 pairs from table
+// on db, in chunks of defaultBatchSize, each executed inside its own
+// transaction. table is validated against the whitelist first, since it's
+// concatenated directly into the query.
+func (s *server) batchDelete(ctx context.Context, db *sql.DB, table string, rows []batchRow) error {
+	ok, err := s.allowedTable(ctx, table)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("unknown table %q", table)
+	}
+
+	for _, b := range batchBounds(len(rows)) {
+		if err := batchDeleteChunk(ctx, db, table, rows[b[0]:b[1]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func batchDeleteChunk(ctx context.Context, db *sql.DB, table string, rows []batchRow) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	clauses := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*2)
+	for i, row := range rows {
+		clauses[i] = "(hash = ? AND range01 = ?)"
+		args = append(args, row.Hash, row.Range01)
+	}
+
+	query := "DELETE FROM " + table + " WHERE " + strings.Join(clauses, " OR ")
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package mysql_store
+
+import "testing"
+
+func TestBatchBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want [][2]int
+	}{
+		{"empty", 0, nil},
+		{"single row", 1, [][2]int{{0, 1}}},
+		{"exactly one batch", defaultBatchSize, [][2]int{{0, defaultBatchSize}}},
+		{"one batch plus one row", defaultBatchSize + 1, [][2]int{{0, defaultBatchSize}, {defaultBatchSize, defaultBatchSize + 1}}},
+		{"exactly two batches", 2 * defaultBatchSize, [][2]int{{0, defaultBatchSize}, {defaultBatchSize, 2 * defaultBatchSize}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := batchBounds(tt.n)
+			if len(got) != len(tt.want) {
+				t.Fatalf("batchBounds(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("batchBounds(%d)[%d] = %v, want %v", tt.n, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchBoundsCoverEveryRowExactlyOnce(t *testing.T) {
+	const n = 3*defaultBatchSize + 7
+	bounds := batchBounds(n)
+
+	covered := make([]bool, n)
+	for _, b := range bounds {
+		if b[1] > n {
+			t.Fatalf("batchBounds(%d) chunk %v exceeds n", n, b)
+		}
+		if b[1]-b[0] > defaultBatchSize {
+			t.Fatalf("batchBounds(%d) chunk %v exceeds defaultBatchSize", n, b)
+		}
+		for i := b[0]; i < b[1]; i++ {
+			if covered[i] {
+				t.Fatalf("batchBounds(%d) covers row %d more than once", n, i)
+			}
+			covered[i] = true
+		}
+	}
+	for i, ok := range covered {
+		if !ok {
+			t.Fatalf("batchBounds(%d) never covers row %d", n, i)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mysql_store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request id stamped by UnaryServerInterceptor
+// / StreamServerInterceptor, or "" if none is present.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// UnaryServerInterceptor stamps every unary RPC with a request id, times it,
+// records mysql_store_request_duration_seconds, and logs one structured
+// line per request. The handlers' own info logs are still emitted alongside
+// it.
+func UnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		reqID := uuid.New().String()
+		ctx = context.WithValue(ctx, requestIDKey{}, reqID)
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		duration := time.Since(start)
+
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		requestDuration.WithLabelValues(info.FullMethod, status).Observe(duration.Seconds())
+
+		logger.Info("handled rpc",
+			zap.String("request_id", reqID),
+			zap.String("method", info.FullMethod),
+			zap.Duration("duration", duration),
+			zap.String("status", status),
+		)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming-RPC equivalent of
+// UnaryServerInterceptor, covering GetChunks/QueryIndex.
+func StreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		reqID := uuid.New().String()
+		ctx := context.WithValue(ss.Context(), requestIDKey{}, reqID)
+
+		start := time.Now()
+		err := handler(srv, &requestIDServerStream{ServerStream: ss, ctx: ctx})
+		duration := time.Since(start)
+
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		requestDuration.WithLabelValues(info.FullMethod, status).Observe(duration.Seconds())
+
+		logger.Info("handled rpc stream",
+			zap.String("request_id", reqID),
+			zap.String("method", info.FullMethod),
+			zap.Duration("duration", duration),
+			zap.String("status", status),
+		)
+		return err
+	}
+}
+
+// requestIDServerStream overrides Context() so handlers can recover the
+// stamped request id via RequestIDFromContext.
+type requestIDServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDServerStream) Context() context.Context {
+	return s.ctx
+}
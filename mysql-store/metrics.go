@@ -0,0 +1,122 @@
+package mysql_store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mysql_store_request_duration_seconds",
+		Help:    "Time taken to serve an RPC, by operation and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op", "status"})
+
+	rowsReadTotal    = prometheus.NewCounter(prometheus.CounterOpts{Name: "mysql_store_rows_read_total", Help: "Total rows returned from GetChunks/QueryIndex."})
+	rowsWrittenTotal = prometheus.NewCounter(prometheus.CounterOpts{Name: "mysql_store_rows_written_total", Help: "Total rows written by PutChunks/WriteIndex."})
+	rowsDeletedTotal = prometheus.NewCounter(prometheus.CounterOpts{Name: "mysql_store_rows_deleted_total", Help: "Total rows removed by DeleteIndex/DeleteChunks."})
+
+	responseSizeBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mysql_store_response_size_bytes",
+		Help:    "Size of gRPC response payloads sent to callers, to tune the flush threshold.",
+		Buckets: prometheus.ExponentialBuckets(1024, 2, 14),
+	})
+
+	dbOpenConns    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "mysql_store_db_open_connections", Help: "sql.DBStats.OpenConnections."})
+	dbInUseConns   = prometheus.NewGauge(prometheus.GaugeOpts{Name: "mysql_store_db_in_use_connections", Help: "sql.DBStats.InUse."})
+	dbIdleConns    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "mysql_store_db_idle_connections", Help: "sql.DBStats.Idle."})
+	dbWaitCount    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "mysql_store_db_wait_count", Help: "sql.DBStats.WaitCount."})
+	dbWaitDuration = prometheus.NewGauge(prometheus.GaugeOpts{Name: "mysql_store_db_wait_duration_seconds", Help: "sql.DBStats.WaitDuration."})
+)
+
+// dbStatsInterval is how often the sql.DBStats gauges are refreshed.
+const dbStatsInterval = 15 * time.Second
+
+// StartMetricsServer registers this package's collectors on a fresh
+// registry and serves them on addr until the process exits.
+func (s *server) StartMetricsServer(addr string) error {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		requestDuration,
+		rowsReadTotal, rowsWrittenTotal, rowsDeletedTotal,
+		responseSizeBytes,
+		dbOpenConns, dbInUseConns, dbIdleConns, dbWaitCount, dbWaitDuration,
+		readsTotal,
+		purgeTablesDroppedTotal, purgeBytesFreedTotal,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			s.Logger.Error("metrics server stopped", zap.Error(err))
+		}
+	}()
+
+	go s.watchDBStats()
+
+	s.Logger.Info("metrics server listening", zap.String("addr", addr))
+	return nil
+}
+
+// defaultMetricsAddr derives the metrics listen address from the gRPC port
+// when Cfg.MetricsListenAddr isn't set explicitly.
+func (s *server) defaultMetricsAddr() string {
+	if s.Cfg.MetricsListenAddr != "" {
+		return s.Cfg.MetricsListenAddr
+	}
+	return fmt.Sprintf(":%d", s.Cfg.GrpcServerPort+1)
+}
+
+// watchDBStats refreshes the connection-pool gauges from sql.DB.Stats() on
+// every shard/replica/primary, summed across pools (each pool counted once),
+// so pool pressure shows up before it starts causing request latency.
+func (s *server) watchDBStats() {
+	ticker := time.NewTicker(dbStatsInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var total sql.DBStats
+		seen := make(map[*sql.DB]bool)
+		for _, db := range s.allPools() {
+			if seen[db] {
+				continue
+			}
+			seen[db] = true
+			st := db.Stats()
+			total.OpenConnections += st.OpenConnections
+			total.InUse += st.InUse
+			total.Idle += st.Idle
+			total.WaitCount += st.WaitCount
+			total.WaitDuration += st.WaitDuration
+		}
+		observeDBStats(total)
+	}
+}
+
+func observeDBStats(stats sql.DBStats) {
+	dbOpenConns.Set(float64(stats.OpenConnections))
+	dbInUseConns.Set(float64(stats.InUse))
+	dbIdleConns.Set(float64(stats.Idle))
+	dbWaitCount.Set(float64(stats.WaitCount))
+	dbWaitDuration.Set(stats.WaitDuration.Seconds())
+}
+
+// allPools returns every *sql.DB this server holds open: the primary/shards
+// plus any read replicas.
+func (s *server) allPools() []*sql.DB {
+	pools := []*sql.DB{s.Session}
+	for _, sh := range s.shards {
+		pools = append(pools, sh.db)
+	}
+	for _, r := range s.replicas {
+		pools = append(pools, r.db)
+	}
+	return pools
+}
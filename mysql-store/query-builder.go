@@ -0,0 +1,220 @@
+package mysql_store
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/VineethReddy02/cortex-mysql-store/grpc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// tableWhitelist caches the set of tables that actually exist in the
+// database, refreshed from information_schema.tables, so that TableName
+// values coming off the wire can be validated before being concatenated into
+// a query rather than being trusted outright.
+type tableWhitelist struct {
+	mu     sync.Mutex
+	tables map[string]struct{}
+}
+
+// allowedTable reports whether table is a known table, refreshing the cache from
+// information_schema.tables if it hasn't been loaded yet or table is absent.
+func (s *server) allowedTable(ctx context.Context, table string) (bool, error) {
+	s.tableWhitelist.mu.Lock()
+	defer s.tableWhitelist.mu.Unlock()
+
+	if _, ok := s.tableWhitelist.tables[table]; ok {
+		return true, nil
+	}
+
+	tables, err := s.loadTableNames(ctx)
+	if err != nil {
+		return false, err
+	}
+	s.tableWhitelist.tables = tables
+
+	_, ok := s.tableWhitelist.tables[table]
+	return ok, nil
+}
+
+func (s *server) loadTableNames(ctx context.Context) (map[string]struct{}, error) {
+	rows, err := s.Session.QueryContext(ctx,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = ?", s.Cfg.Database)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	tables := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tables[name] = struct{}{}
+	}
+	return tables, rows.Err()
+}
+
+// buildIndexQuery turns a QueryIndexRequest into a parameterized SELECT,
+// replacing the previous six near-identical fmt.Sprintf branches.
+func buildIndexQuery(table string, query *grpc.QueryIndexRequest) (string, []interface{}) {
+	sqlQuery := "SELECT range01, value FROM " + table + " WHERE hash = ?"
+	args := []interface{}{query.HashValue}
+
+	switch {
+	case len(query.RangeValuePrefix) > 0:
+		sqlQuery += " AND range01 >= ? AND range01 < ?"
+		args = append(args, query.RangeValuePrefix, append(append([]byte{}, query.RangeValuePrefix...), '\xff'))
+	case len(query.RangeValueStart) > 0:
+		sqlQuery += " AND range01 >= ?"
+		args = append(args, query.RangeValueStart)
+	}
+
+	if query.ValueEqual != nil {
+		sqlQuery += " AND value = ?"
+		args = append(args, query.ValueEqual)
+	}
+
+	return sqlQuery, args
+}
+
+// queryPages fans queries out over a bounded worker pool (sized to
+// GOMAXPROCS by default) and streams matching rows back through send as they
+// arrive. send is called with at most one in-flight invocation at a time.
+func (s *server) queryPages(ctx context.Context, queries []*grpc.QueryIndexRequest, send func([]*grpc.Row) error) error {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(queries) {
+		workers = len(queries)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		sendMu   sync.Mutex
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	jobs := make(chan *grpc.QueryIndexRequest)
+	setErr := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for query := range jobs {
+				if err := s.runIndexQuery(ctx, query, func(rows []*grpc.Row) error {
+					sendMu.Lock()
+					defer sendMu.Unlock()
+					return send(rows)
+				}); err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+
+	for _, query := range queries {
+		select {
+		case jobs <- query:
+		case <-ctx.Done():
+			setErr(ctx.Err())
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// runIndexQuery executes a single QueryIndexRequest and batches matching rows
+// into send, using the same 4 MiB accounting as the rest of the gRPC
+// responses.
+func (s *server) runIndexQuery(ctx context.Context, query *grpc.QueryIndexRequest, send func([]*grpc.Row) error) error {
+	ctx, span := startSpan(ctx, "QueryIndex", "SELECT", query.TableName)
+	var rowsSent int
+	var err error
+	defer func() { endSpan(span, rowsSent, err) }()
+
+	ok, err := s.allowedTable(ctx, query.TableName)
+	if err != nil {
+		s.Logger.Error("failed to validate table name", zap.Error(err))
+		return err
+	}
+	if !ok {
+		err = errors.Errorf("unknown table %q", query.TableName)
+		return err
+	}
+
+	sqlQuery, args := buildIndexQuery(query.TableName, query)
+	rows, err := s.readDBFor(query.HashValue).QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		s.Logger.Error("failed to perform index query in query pages", zap.Error(err))
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		bs   []*grpc.Row
+		size int
+	)
+	for rows.Next() {
+		b := &grpc.Row{}
+		if err := rows.Scan(&b.RangeValue, &b.Value); err != nil {
+			s.Logger.Error("failed to scan row in query pages", zap.Error(err))
+			return err
+		}
+		bs = append(bs, b)
+		size += len(b.RangeValue)
+		size += len(b.Value)
+
+		if size > 1024*1024*4/10*8 {
+			var last *grpc.Row
+			// 4MiB / 10 * 9 = 3.60 MiB, leaves the room for other data fields
+			// and make response size being less than 4 MiB (which gRPC recommand limitations)
+			if size > 1024*1024*4/10*9 {
+				if len(bs) > 1 {
+					last = bs[len(bs)-1]
+					bs = bs[:len(bs)-1]
+				} else {
+					s.Logger.Warn("response is too large")
+				}
+			}
+			responseSizeBytes.Observe(float64(size))
+			if err = send(bs); err != nil {
+				return err
+			}
+			rowsSent += len(bs)
+			rowsReadTotal.Add(float64(len(bs)))
+			bs = nil
+			size = 0
+			if last != nil {
+				bs = append(bs, last)
+				size += len(last.RangeValue)
+				size += len(last.Value)
+			}
+		}
+	}
+
+	if len(bs) > 0 {
+		if size > 1024*1024*4/10*9 {
+			s.Logger.Warn("response is too large")
+		}
+		responseSizeBytes.Observe(float64(size))
+		if err = send(bs); err != nil {
+			return err
+		}
+		rowsSent += len(bs)
+		rowsReadTotal.Add(float64(len(bs)))
+	}
+
+	err = rows.Err()
+	return err
+}
@@ -0,0 +1,89 @@
+package mysql_store
+
+import (
+	"testing"
+
+	"github.com/VineethReddy02/cortex-mysql-store/grpc"
+)
+
+func TestBuildIndexQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    *grpc.QueryIndexRequest
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "hash only",
+			query:    &grpc.QueryIndexRequest{HashValue: []byte("h")},
+			wantSQL:  "SELECT range01, value FROM t WHERE hash = ?",
+			wantArgs: []interface{}{[]byte("h")},
+		},
+		{
+			name: "range prefix",
+			query: &grpc.QueryIndexRequest{
+				HashValue:        []byte("h"),
+				RangeValuePrefix: []byte("p"),
+			},
+			wantSQL:  "SELECT range01, value FROM t WHERE hash = ? AND range01 >= ? AND range01 < ?",
+			wantArgs: []interface{}{[]byte("h"), []byte("p"), []byte("p\xff")},
+		},
+		{
+			name: "range start",
+			query: &grpc.QueryIndexRequest{
+				HashValue:       []byte("h"),
+				RangeValueStart: []byte("s"),
+			},
+			wantSQL:  "SELECT range01, value FROM t WHERE hash = ? AND range01 >= ?",
+			wantArgs: []interface{}{[]byte("h"), []byte("s")},
+		},
+		{
+			name: "value equal",
+			query: &grpc.QueryIndexRequest{
+				HashValue:  []byte("h"),
+				ValueEqual: []byte("v"),
+			},
+			wantSQL:  "SELECT range01, value FROM t WHERE hash = ? AND value = ?",
+			wantArgs: []interface{}{[]byte("h"), []byte("v")},
+		},
+		{
+			name: "range prefix and value equal combine",
+			query: &grpc.QueryIndexRequest{
+				HashValue:        []byte("h"),
+				RangeValuePrefix: []byte("p"),
+				ValueEqual:       []byte("v"),
+			},
+			wantSQL:  "SELECT range01, value FROM t WHERE hash = ? AND range01 >= ? AND range01 < ? AND value = ?",
+			wantArgs: []interface{}{[]byte("h"), []byte("p"), []byte("p\xff"), []byte("v")},
+		},
+		{
+			name: "range prefix wins over range start",
+			query: &grpc.QueryIndexRequest{
+				HashValue:        []byte("h"),
+				RangeValuePrefix: []byte("p"),
+				RangeValueStart:  []byte("s"),
+			},
+			wantSQL:  "SELECT range01, value FROM t WHERE hash = ? AND range01 >= ? AND range01 < ?",
+			wantArgs: []interface{}{[]byte("h"), []byte("p"), []byte("p\xff")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlQuery, args := buildIndexQuery("t", tt.query)
+			if sqlQuery != tt.wantSQL {
+				t.Fatalf("buildIndexQuery() sql = %q, want %q", sqlQuery, tt.wantSQL)
+			}
+			if len(args) != len(tt.wantArgs) {
+				t.Fatalf("buildIndexQuery() args = %v, want %v", args, tt.wantArgs)
+			}
+			for i := range args {
+				a, _ := args[i].([]byte)
+				b, _ := tt.wantArgs[i].([]byte)
+				if string(a) != string(b) {
+					t.Fatalf("buildIndexQuery() args[%d] = %q, want %q", i, a, b)
+				}
+			}
+		})
+	}
+}
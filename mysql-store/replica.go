@@ -0,0 +1,124 @@
+package mysql_store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var readsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mysql_store_reads_total",
+	Help: "Total number of reads routed by role and address.",
+}, []string{"role", "addr"})
+
+// defaultReplicaHealthCheckInterval is used when Cfg.ReplicaHealthCheckInterval is unset.
+const defaultReplicaHealthCheckInterval = 5 * time.Second
+
+// replica is a single read-only MySQL backend, tracked for round-robin
+// routing and health eviction.
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy int32 // accessed atomically; 1 = healthy, 0 = unhealthy
+}
+
+// openReplicas dials one *sql.DB per address in Cfg.ReadAddresses and starts
+// the background health checker that evicts unreachable replicas from the
+// read rotation. Replicas only back the non-sharded primary session; a
+// sharded deployment always reads from the owning shard.
+func (s *server) openReplicas() error {
+	for _, addr := range strings.Split(s.Cfg.ReadAddresses, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		db, err := s.dialReplica(addr)
+		if err != nil {
+			s.Logger.Error("failed to dial mysql read replica", zap.String("addr", addr), zap.Error(err))
+			continue
+		}
+		s.replicas = append(s.replicas, &replica{addr: addr, db: db, healthy: 1})
+	}
+
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	go s.watchReplicaHealth()
+	return nil
+}
+
+func (s *server) dialReplica(addr string) (*sql.DB, error) {
+	dataSourceName := s.Cfg.Username + ":" + s.Cfg.Password + "@tcp(" + addr + ":" + strconv.Itoa(s.Cfg.Port) + ")/" +
+		s.Cfg.Database + buildDSNParams(s.Cfg)
+	db, err := sql.Open("mysql", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(s.Cfg.MaxOpenConns)
+	db.SetMaxIdleConns(s.Cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(s.Cfg.ConnMaxLifetime)
+	return db, nil
+}
+
+// watchReplicaHealth pings every replica on an interval, marking it
+// unhealthy (and out of rotation) when the ping fails, and healthy again
+// once it recovers.
+func (s *server) watchReplicaHealth() {
+	interval := s.Cfg.ReplicaHealthCheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaHealthCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, r := range s.replicas {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := r.db.PingContext(ctx)
+			cancel()
+
+			wasHealthy := atomic.LoadInt32(&r.healthy) == 1
+			if err != nil {
+				atomic.StoreInt32(&r.healthy, 0)
+				if wasHealthy {
+					s.Logger.Warn("mysql read replica marked unhealthy", zap.String("addr", r.addr), zap.Error(err))
+				}
+			} else {
+				atomic.StoreInt32(&r.healthy, 1)
+				if !wasHealthy {
+					s.Logger.Info("mysql read replica recovered", zap.String("addr", r.addr))
+				}
+			}
+		}
+	}
+}
+
+// readDB picks a healthy replica round-robin, falling back to the primary
+// session when no replica is healthy (or none are configured).
+func (s *server) readDB() *sql.DB {
+	if len(s.replicas) == 0 {
+		readsTotal.WithLabelValues("primary", s.Cfg.Addresses).Inc()
+		return s.Session
+	}
+
+	n := len(s.replicas)
+	start := int(atomic.AddUint64(&s.replicaRR, 1))
+	for i := 0; i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			readsTotal.WithLabelValues("replica", r.addr).Inc()
+			return r.db
+		}
+	}
+
+	s.Logger.Warn("no healthy mysql read replica, falling back to primary")
+	readsTotal.WithLabelValues("primary", s.Cfg.Addresses).Inc()
+	return s.Session
+}
@@ -0,0 +1,170 @@
+package mysql_store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// replicaLagThrottle is the Seconds_Behind_Master above which onlineAlterTable
+// pauses copying rows, giving replication a chance to catch up.
+const replicaLagThrottle = 5
+
+// onlineAlterTableBatchSize is the number of rows copied from the original
+// table to the ghost table per iteration.
+const onlineAlterTableBatchSize = 1000
+
+// onlineAlterTable changes table's shape without holding a write lock on it,
+// using the same create-ghost / copy-rows / atomic-rename cut-over gh-ost
+// uses: a plain `ALTER TABLE` on a hot weekly index table would otherwise
+// block writes for as long as the alter takes.
+func (s *server) onlineAlterTable(ctx context.Context, table, alterStmt string) error {
+	ghost := table + "_ghost"
+	old := table + "_old"
+
+	if _, err := s.Session.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s LIKE %s", ghost, table)); err != nil {
+		return errors.Wrapf(err, "failed to create ghost table %s", ghost)
+	}
+	if _, err := s.Session.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s %s", ghost, alterStmt)); err != nil {
+		return errors.Wrapf(err, "failed to alter ghost table %s", ghost)
+	}
+
+	if err := s.copyRowsThrottled(ctx, table, ghost); err != nil {
+		return errors.Wrapf(err, "failed to copy rows from %s to %s", table, ghost)
+	}
+
+	_, err := s.Session.ExecContext(ctx, fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", table, old, ghost, table))
+	if err != nil {
+		return errors.Wrapf(err, "failed to cut over %s to %s", table, ghost)
+	}
+
+	s.Logger.Info("online schema change complete", zap.String("table", table), zap.String("previous", old))
+	return nil
+}
+
+// copyRowsThrottled copies every row from src to dst in bounded hash-range
+// chunks, pausing between chunks when the replica is falling behind. The
+// copy is done over src's own column list rather than `SELECT *` so that it
+// still works once dst (the ghost table) has gained columns src doesn't
+// have, which is the entire point of running an ALTER on the ghost first.
+func (s *server) copyRowsThrottled(ctx context.Context, src, dst string) error {
+	cols, err := s.tableColumns(ctx, src)
+	if err != nil {
+		return err
+	}
+	colList := strings.Join(cols, ", ")
+
+	lastHash, lastRange := []byte{}, []byte{}
+	for {
+		if err := s.waitForReplicationCatchUp(ctx); err != nil {
+			return err
+		}
+
+		res, err := s.Session.ExecContext(ctx, fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT %s FROM %s WHERE (hash, range01) > (?, ?) ORDER BY hash, range01 LIMIT %d",
+			dst, colList, colList, src, onlineAlterTableBatchSize), lastHash, lastRange)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		copied, err := res.RowsAffected()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if copied == 0 {
+			return nil
+		}
+
+		row := s.Session.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT hash, range01 FROM %s ORDER BY hash DESC, range01 DESC LIMIT 1", dst))
+		if err := row.Scan(&lastHash, &lastRange); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// tableColumns returns table's column names in ordinal order, read from
+// information_schema.columns.
+func (s *server) tableColumns(ctx context.Context, table string) ([]string, error) {
+	rows, err := s.Session.QueryContext(ctx,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ? ORDER BY ordinal_position",
+		s.Cfg.Database, table)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// waitForReplicationCatchUp polls SHOW SLAVE STATUS and blocks while
+// Seconds_Behind_Master exceeds replicaLagThrottle.
+func (s *server) waitForReplicationCatchUp(ctx context.Context) error {
+	for {
+		lag, ok, err := s.replicationLag(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok || lag <= replicaLagThrottle {
+			return nil
+		}
+
+		s.Logger.Warn("throttling online schema change for replication lag", zap.Int64("seconds_behind_master", lag))
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// replicationLag reads Seconds_Behind_Master from SHOW SLAVE STATUS. ok is
+// false when the server isn't a replica (the column comes back NULL or the
+// statement returns no rows), in which case there's nothing to throttle on.
+func (s *server) replicationLag(ctx context.Context) (int64, bool, error) {
+	rows, err := s.Session.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+
+	if !rows.Next() {
+		return 0, false, nil
+	}
+
+	dest := make([]interface{}, len(cols))
+	var secondsBehind sql.NullInt64
+	for i, col := range cols {
+		if col == "Seconds_Behind_Master" {
+			dest[i] = &secondsBehind
+		} else {
+			dest[i] = new(interface{})
+		}
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+
+	if !secondsBehind.Valid {
+		return 0, false, nil
+	}
+	return secondsBehind.Int64, true, nil
+}
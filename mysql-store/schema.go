@@ -0,0 +1,133 @@
+package mysql_store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// tableDDL creates one of this store's tables; index and chunk tables share
+// the same (hash, range01, value) layout.
+const tableDDL = "CREATE TABLE IF NOT EXISTS %s (" +
+	"hash VARBINARY(255) NOT NULL, " +
+	"range01 VARBINARY(255) NOT NULL, " +
+	"value MEDIUMBLOB, " +
+	"PRIMARY KEY(hash, range01)" +
+	") ENGINE=InnoDB ROW_FORMAT=DYNAMIC"
+
+// schemaSyncInterval is how often EnsureSchemaTables is re-run in the
+// background to pick up upcoming periodic tables before they're needed.
+const schemaSyncInterval = time.Hour
+
+// EnsureSchemaTables walks SchemaCfg.Configs and creates the active and next
+// upcoming periodic index/chunk table for each, so a fresh deployment
+// doesn't fail its first write against a table that doesn't exist yet.
+func (s *server) EnsureSchemaTables(ctx context.Context) error {
+	now := time.Now()
+	for _, table := range s.periodicTableNames(now) {
+		if err := s.CreateTable(ctx, chunk.TableDesc{Name: table}); err != nil {
+			return errors.Wrapf(err, "failed to create table %s", table)
+		}
+	}
+	return nil
+}
+
+// WatchSchemaTables runs EnsureSchemaTables on startup and then once per
+// schemaSyncInterval until ctx is cancelled, so weekly/daily rollovers get
+// their table created ahead of time.
+func (s *server) WatchSchemaTables(ctx context.Context) {
+	if err := s.EnsureSchemaTables(ctx); err != nil {
+		s.Logger.Error("failed to ensure schema tables", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(schemaSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.EnsureSchemaTables(ctx); err != nil {
+				s.Logger.Error("failed to ensure schema tables", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// periodicTableNames returns the active and next-period table name for every
+// index/chunk periodic table config in the schema.
+func (s *server) periodicTableNames(at time.Time) []string {
+	var names []string
+	for _, cfg := range s.SchemaCfg.Configs {
+		for _, pc := range []chunk.PeriodicTableConfig{cfg.IndexTables, cfg.ChunkTables} {
+			if pc.Prefix == "" {
+				continue
+			}
+			names = append(names, periodicTableName(pc, at))
+			if pc.Period > 0 {
+				names = append(names, periodicTableName(pc, at.Add(pc.Period)))
+			}
+		}
+	}
+	return names
+}
+
+// periodicTableName computes the table name for the period containing t,
+// matching Cortex's "<prefix><period-number>" periodic table scheme.
+func periodicTableName(pc chunk.PeriodicTableConfig, t time.Time) string {
+	if pc.Period <= 0 {
+		return pc.Prefix
+	}
+	periodNum := t.Unix() / int64(pc.Period/time.Second)
+	return fmt.Sprintf("%s%d", pc.Prefix, periodNum)
+}
+
+// ListTables implements chunk.TableClient.
+func (s *server) ListTables(ctx context.Context) ([]string, error) {
+	tables, err := s.loadTableNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// CreateTable implements chunk.TableClient.
+func (s *server) CreateTable(ctx context.Context, desc chunk.TableDesc) error {
+	if _, err := s.Session.ExecContext(ctx, fmt.Sprintf(tableDDL, desc.Name)); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// DeleteTable implements chunk.TableClient.
+func (s *server) DeleteTable(ctx context.Context, name string) error {
+	if _, err := s.Session.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", name)); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// DescribeTable implements chunk.TableClient.
+func (s *server) DescribeTable(ctx context.Context, name string) (chunk.TableDesc, bool, error) {
+	ok, err := s.allowedTable(ctx, name)
+	if err != nil {
+		return chunk.TableDesc{}, false, err
+	}
+	return chunk.TableDesc{Name: name}, ok, nil
+}
+
+// UpdateTable implements chunk.TableClient. MySQL tables need no throughput
+// provisioning, unlike the DynamoDB-backed stores this interface was
+// designed for, so there is nothing to reconcile here; schema changes go
+// through onlineAlterTable instead.
+func (s *server) UpdateTable(ctx context.Context, current, expected chunk.TableDesc) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package mysql_store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+func TestPeriodicTableNameAndBoundsRoundTrip(t *testing.T) {
+	pc := chunk.PeriodicTableConfig{Prefix: "index_", Period: 24 * time.Hour}
+
+	tests := []struct {
+		name string
+		at   time.Time
+	}{
+		{"epoch", time.Unix(0, 0)},
+		{"mid period", time.Unix(0, 0).Add(12 * time.Hour)},
+		{"many periods out", time.Unix(0, 0).Add(100 * 24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := periodicTableName(pc, tt.at)
+
+			start, end := periodicTableBounds(pc, name)
+			if !start.Before(tt.at.Add(time.Second)) || !end.After(tt.at) {
+				t.Fatalf("periodicTableBounds(%q) = [%v, %v), does not contain %v", name, start, end, tt.at)
+			}
+			if end.Sub(start) != pc.Period {
+				t.Fatalf("periodicTableBounds(%q) span = %v, want %v", name, end.Sub(start), pc.Period)
+			}
+
+			// periodicTableName is deterministic for any instant within the bounds.
+			if got := periodicTableName(pc, start); got != name {
+				t.Fatalf("periodicTableName(start) = %q, want %q", got, name)
+			}
+			if got := periodicTableName(pc, end.Add(-time.Nanosecond)); got != name {
+				t.Fatalf("periodicTableName(end-1ns) = %q, want %q", got, name)
+			}
+		})
+	}
+}
+
+func TestPeriodicTableNameHasExpectedPrefix(t *testing.T) {
+	pc := chunk.PeriodicTableConfig{Prefix: "chunk_", Period: 7 * 24 * time.Hour}
+	name := periodicTableName(pc, time.Unix(1_700_000_000, 0))
+
+	wantPrefix := "chunk_"
+	if len(name) < len(wantPrefix) || name[:len(wantPrefix)] != wantPrefix {
+		t.Fatalf("periodicTableName() = %q, want prefix %q", name, wantPrefix)
+	}
+
+	var periodNum int64
+	if _, err := fmt.Sscanf(name[len(wantPrefix):], "%d", &periodNum); err != nil {
+		t.Fatalf("periodicTableName() suffix not numeric: %q", name)
+	}
+}
+
+func TestPeriodicTableNameNoPeriodReturnsBarePrefix(t *testing.T) {
+	pc := chunk.PeriodicTableConfig{Prefix: "static_table"}
+	if got := periodicTableName(pc, time.Now()); got != "static_table" {
+		t.Fatalf("periodicTableName() with no period = %q, want bare prefix %q", got, pc.Prefix)
+	}
+}
@@ -0,0 +1,251 @@
+package mysql_store
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ShardingConfig controls horizontal sharding of the index/chunk tables
+// across multiple independent MySQL backends, keyed by hash.
+type ShardingConfig struct {
+	Enabled           bool     `yaml:"enabled,omitempty"`
+	Algorithm         string   `yaml:"algorithm,omitempty"`
+	ShardCount        int      `yaml:"shard_count,omitempty"`
+	ShardAddresses    []string `yaml:"shard_addresses,omitempty"`
+	ReplicationFactor int      `yaml:"replication_factor,omitempty"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *ShardingConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "mysql.sharding.enabled", false, "Shard index/chunk tables across the hosts in mysql.addresses.")
+	f.StringVar(&cfg.Algorithm, "mysql.sharding.algorithm", "hash_mod", "Sharding algorithm to use, only hash_mod is supported today.")
+	f.IntVar(&cfg.ShardCount, "mysql.sharding.shard-count", 0, "Number of shards; defaults to the number of addresses when 0.")
+	f.IntVar(&cfg.ReplicationFactor, "mysql.sharding.replication-factor", 1, "Number of shards each key is written to.")
+}
+
+// shard is a single horizontally-sharded MySQL backend.
+type shard struct {
+	index   int
+	addr    string
+	db      *sql.DB
+	healthy int32 // accessed atomically; 1 = healthy, 0 = unhealthy
+}
+
+// openShards parses Cfg.Addresses (or the ShardAddresses override) into one
+// *sql.DB per shard, dialed with the same TLS/pool settings as session().
+func (s *server) openShards() error {
+	addrs := s.Cfg.Sharding.ShardAddresses
+	if len(addrs) == 0 {
+		for _, addr := range strings.Split(s.Cfg.Addresses, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return errors.New("mysql sharding enabled but no addresses configured")
+	}
+
+	shardCount := s.Cfg.Sharding.ShardCount
+	if shardCount == 0 {
+		shardCount = len(addrs)
+	}
+
+	shards := make([]shard, shardCount)
+	for i := range shards {
+		addr := addrs[i%len(addrs)]
+		db, err := s.dialShard(addr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to dial shard %d (%s)", i, addr)
+		}
+		shards[i] = shard{index: i, addr: addr, db: db, healthy: 1}
+	}
+
+	s.shards = shards
+	s.Session = shards[0].db
+	s.Logger.Info("mysql shards connected", zap.Int("shards", len(shards)))
+
+	go s.watchShardHealth()
+	return nil
+}
+
+// watchShardHealth pings every shard on an interval, marking it unhealthy
+// (and skipped by readDBFor's failover) when the ping fails, healthy again
+// once it recovers, mirroring watchReplicaHealth.
+func (s *server) watchShardHealth() {
+	interval := s.Cfg.ReplicaHealthCheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaHealthCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for i := range s.shards {
+			sh := &s.shards[i]
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := sh.db.PingContext(ctx)
+			cancel()
+
+			wasHealthy := atomic.LoadInt32(&sh.healthy) == 1
+			if err != nil {
+				atomic.StoreInt32(&sh.healthy, 0)
+				if wasHealthy {
+					s.Logger.Warn("mysql shard marked unhealthy", zap.String("addr", sh.addr), zap.Error(err))
+				}
+			} else {
+				atomic.StoreInt32(&sh.healthy, 1)
+				if !wasHealthy {
+					s.Logger.Info("mysql shard recovered", zap.String("addr", sh.addr))
+				}
+			}
+		}
+	}
+}
+
+// dialShard opens, provisions and pings a single shard's connection, mirroring
+// the non-sharded session() setup for a given host.
+func (s *server) dialShard(addr string) (*sql.DB, error) {
+	dataSourceName := s.Cfg.Username + ":" + s.Cfg.Password + "@tcp(" + addr + ":" + strconv.Itoa(s.Cfg.Port) + ")/"
+	dsnParams := buildDSNParams(s.Cfg)
+
+	db, err := sql.Open("mysql", dataSourceName+dsnParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", s.Cfg.Database)); err != nil {
+		return nil, err
+	}
+
+	db, err = sql.Open("mysql", dataSourceName+s.Cfg.Database+dsnParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("USE %s", s.Cfg.Database)); err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(s.Cfg.MaxOpenConns)
+	db.SetMaxIdleConns(s.Cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(s.Cfg.ConnMaxLifetime)
+
+	return db, nil
+}
+
+// shardFor returns the shard that owns hashKey, hashing with xxhash so that
+// routing is stable across process restarts.
+func (s *server) shardFor(hashKey []byte) *shard {
+	idx := int(xxhash.Sum64(hashKey) % uint64(len(s.shards)))
+	return &s.shards[idx]
+}
+
+// shardsFor returns the shards hashKey is replicated to: the shard selected
+// by shardFor followed by the next Cfg.Sharding.ReplicationFactor-1 shards
+// (wrapping around), so that writes for a key land on every shard a reader
+// might pick it up from. The factor is clamped to the number of shards.
+func (s *server) shardsFor(hashKey []byte) []*shard {
+	n := s.Cfg.Sharding.ReplicationFactor
+	if n < 1 {
+		n = 1
+	}
+	if n > len(s.shards) {
+		n = len(s.shards)
+	}
+
+	idx := int(xxhash.Sum64(hashKey) % uint64(len(s.shards)))
+	shards := make([]*shard, n)
+	for i := 0; i < n; i++ {
+		shards[i] = &s.shards[(idx+i)%len(s.shards)]
+	}
+	return shards
+}
+
+// dbFor returns the *sql.DB that owns hashKey for writes: the primary
+// session when sharding is disabled, or the shard selected by shardFor
+// otherwise.
+func (s *server) dbFor(hashKey []byte) *sql.DB {
+	if len(s.shards) == 0 {
+		return s.Session
+	}
+	return s.shardFor(hashKey).db
+}
+
+// dbsFor returns every *sql.DB hashKey should be written to: the primary
+// session when sharding is disabled, or one *sql.DB per shard in
+// shardsFor otherwise, so that callers writing a key replicate it across
+// Cfg.Sharding.ReplicationFactor shards instead of just the one it hashes to.
+func (s *server) dbsFor(hashKey []byte) []*sql.DB {
+	if len(s.shards) == 0 {
+		return []*sql.DB{s.Session}
+	}
+	shards := s.shardsFor(hashKey)
+	dbs := make([]*sql.DB, len(shards))
+	for i, sh := range shards {
+		dbs[i] = sh.db
+	}
+	return dbs
+}
+
+// readDBFor returns the *sql.DB to read hashKey from: a healthy read replica
+// when sharding is disabled and replicas are configured, or, when sharding
+// is enabled, the first healthy shard among the ReplicationFactor shards
+// shardsFor replicated the key's writes to -- failing over to those shards
+// when the primary owner is down, since that's exactly where dbsFor also
+// wrote the row. This is read failover across the replicated shards, not
+// the scatter-gather-and-merge-across-all-shards some stores do; a hash not
+// found on any of its shardsFor shards is not searched for elsewhere.
+func (s *server) readDBFor(hashKey []byte) *sql.DB {
+	if len(s.shards) == 0 {
+		return s.readDB()
+	}
+
+	shards := s.shardsFor(hashKey)
+	for _, sh := range shards {
+		if atomic.LoadInt32(&sh.healthy) == 1 {
+			return sh.db
+		}
+	}
+
+	s.Logger.Warn("no healthy shard for key, falling back to primary owner", zap.String("addr", shards[0].addr))
+	return shards[0].db
+}
+
+// ShardMapEntry describes one shard's placement as reported by ShardMap.
+type ShardMapEntry struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+}
+
+// ShardMap reports the current shard placement so operators can verify where
+// a given key lands. It is a method on server only; the GrpcStore service
+// does not expose it.
+func (s *server) ShardMap(context.Context, *empty.Empty) (*ShardMapResponse, error) {
+	resp := &ShardMapResponse{Shards: make([]ShardMapEntry, len(s.shards))}
+	for i, sh := range s.shards {
+		resp.Shards[i] = ShardMapEntry{Index: sh.index, Address: sh.addr}
+	}
+	return resp, nil
+}
+
+// ShardMapResponse is the result of ShardMap.
+type ShardMapResponse struct {
+	Shards []ShardMapEntry `json:"shards"`
+}
@@ -0,0 +1,77 @@
+package mysql_store
+
+import (
+	"testing"
+)
+
+func newTestShards(n int) []shard {
+	shards := make([]shard, n)
+	for i := range shards {
+		shards[i] = shard{index: i, addr: "addr", healthy: 1}
+	}
+	return shards
+}
+
+func TestShardForIsStableAndInRange(t *testing.T) {
+	s := &server{shards: newTestShards(4)}
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("some-hash-key"), []byte{0x00, 0xff}}
+	for _, key := range keys {
+		first := s.shardFor(key)
+		for i := 0; i < 10; i++ {
+			again := s.shardFor(key)
+			if again.index != first.index {
+				t.Fatalf("shardFor(%q) not stable: got shard %d then %d", key, first.index, again.index)
+			}
+		}
+		if first.index < 0 || first.index >= len(s.shards) {
+			t.Fatalf("shardFor(%q) returned out-of-range shard %d", key, first.index)
+		}
+	}
+}
+
+func TestShardsForReplicationFactor(t *testing.T) {
+	tests := []struct {
+		name              string
+		shardCount        int
+		replicationFactor int
+		wantLen           int
+	}{
+		{"factor 1", 4, 1, 1},
+		{"factor 3", 4, 3, 3},
+		{"factor clamps to shard count", 4, 10, 4},
+		{"factor 0 treated as 1", 4, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{shards: newTestShards(tt.shardCount)}
+			s.Cfg.Sharding.ReplicationFactor = tt.replicationFactor
+
+			shards := s.shardsFor([]byte("some-key"))
+			if len(shards) != tt.wantLen {
+				t.Fatalf("shardsFor() returned %d shards, want %d", len(shards), tt.wantLen)
+			}
+
+			seen := make(map[int]bool)
+			for _, sh := range shards {
+				if seen[sh.index] {
+					t.Fatalf("shardsFor() returned duplicate shard index %d", sh.index)
+				}
+				seen[sh.index] = true
+			}
+		})
+	}
+}
+
+func TestShardsForStartsAtShardFor(t *testing.T) {
+	s := &server{shards: newTestShards(5)}
+	s.Cfg.Sharding.ReplicationFactor = 3
+
+	key := []byte("another-key")
+	owner := s.shardFor(key)
+	shards := s.shardsFor(key)
+	if shards[0].index != owner.index {
+		t.Fatalf("shardsFor()[0] = shard %d, want the shardFor() owner %d", shards[0].index, owner.index)
+	}
+}
@@ -2,15 +2,18 @@ package mysql_store
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"time"
 
 	"github.com/VineethReddy02/cortex-mysql-store/grpc"
 	"github.com/cortexproject/cortex/pkg/chunk"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -18,23 +21,29 @@ import (
 
 // Config for a StorageClient
 type Config struct {
-	Addresses                string        `yaml:"addresses,omitempty"`
-	GrpcServerPort           int           `yaml:"http_listen_port,omitempty"`
-	Port                     int           `yaml:"port,omitempty"`
-	Database                 string        `yaml:"database,omitempty"`
-	DBUser                   string        `yaml:"dbuser,omitempty"`
-	DBPassword               string        `yaml:"dbpassword,omitempty"`
-	Consistency              string        `yaml:"consistency,omitempty"`
-	ReplicationFactor        int           `yaml:"replication_factor,omitempty"`
-	DisableInitialHostLookup bool          `yaml:"disable_initial_host_lookup,omitempty"`
-	SSL                      bool          `yaml:"SSL,omitempty"`
-	HostVerification         bool          `yaml:"host_verification,omitempty"`
-	CAPath                   string        `yaml:"CA_path,omitempty"`
-	Auth                     bool          `yaml:"auth,omitempty"`
-	Username                 string        `yaml:"username,omitempty"`
-	Password                 string        `yaml:"password,omitempty"`
-	Timeout                  time.Duration `yaml:"timeout,omitempty"`
-	ConnectTimeout           time.Duration `yaml:"connect_timeout,omitempty"`
+	Addresses                  string         `yaml:"addresses,omitempty"`
+	GrpcServerPort             int            `yaml:"http_listen_port,omitempty"`
+	Port                       int            `yaml:"port,omitempty"`
+	Database                   string         `yaml:"database,omitempty"`
+	DBUser                     string         `yaml:"dbuser,omitempty"`
+	DBPassword                 string         `yaml:"dbpassword,omitempty"`
+	Consistency                string         `yaml:"consistency,omitempty"`
+	DisableInitialHostLookup   bool           `yaml:"disable_initial_host_lookup,omitempty"`
+	SSL                        bool           `yaml:"SSL,omitempty"`
+	HostVerification           bool           `yaml:"host_verification,omitempty"`
+	CAPath                     string         `yaml:"CA_path,omitempty"`
+	Auth                       bool           `yaml:"auth,omitempty"`
+	Username                   string         `yaml:"username,omitempty"`
+	Password                   string         `yaml:"password,omitempty"`
+	Timeout                    time.Duration  `yaml:"timeout,omitempty"`
+	ConnectTimeout             time.Duration  `yaml:"connect_timeout,omitempty"`
+	MaxOpenConns               int            `yaml:"max_open_conns,omitempty"`
+	MaxIdleConns               int            `yaml:"max_idle_conns,omitempty"`
+	ConnMaxLifetime            time.Duration  `yaml:"conn_max_lifetime,omitempty"`
+	Sharding                   ShardingConfig `yaml:"sharding,omitempty"`
+	ReadAddresses              string         `yaml:"read_addresses,omitempty"`
+	ReplicaHealthCheckInterval time.Duration  `yaml:"replica_health_check_interval,omitempty"`
+	MetricsListenAddr          string         `yaml:"metrics_listen_addr,omitempty"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet
@@ -45,6 +54,61 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.Database, "mysql.database", "", "DB to use in mysql.")
 	f.StringVar(&cfg.DBUser, "mysql.dbuser", "", "DB user to use in mysql.")
 	f.StringVar(&cfg.DBPassword, "mysql.dbpassword", "", "DB password to use in mysql.")
+	f.BoolVar(&cfg.SSL, "mysql.ssl", false, "Enable TLS when connecting to mysql.")
+	f.BoolVar(&cfg.HostVerification, "mysql.host-verification", true, "Validate the mysql server certificate against the hostname.")
+	f.StringVar(&cfg.CAPath, "mysql.ca-path", "", "Path to the CA cert bundle used to verify the mysql server certificate.")
+	f.DurationVar(&cfg.Timeout, "mysql.timeout", 0, "Timeout for establishing connections to mysql.")
+	f.DurationVar(&cfg.ConnectTimeout, "mysql.connect-timeout", 0, "Timeout for reading/writing to mysql connections.")
+	f.IntVar(&cfg.MaxOpenConns, "mysql.max-open-conns", 0, "Maximum number of open connections to mysql (0 = unlimited).")
+	f.IntVar(&cfg.MaxIdleConns, "mysql.max-idle-conns", 2, "Maximum number of idle connections to mysql.")
+	f.DurationVar(&cfg.ConnMaxLifetime, "mysql.conn-max-lifetime", 0, "Maximum amount of time a mysql connection may be reused (0 = unlimited).")
+	f.StringVar(&cfg.ReadAddresses, "mysql.read-addresses", "", "Comma-separated hostnames or IPs of mysql read replicas.")
+	f.DurationVar(&cfg.ReplicaHealthCheckInterval, "mysql.replica-health-check-interval", defaultReplicaHealthCheckInterval, "How often to ping read replicas to evict unhealthy ones from the rotation.")
+	f.StringVar(&cfg.MetricsListenAddr, "mysql.metrics-listen-addr", "", "Address to serve Prometheus metrics on; defaults to :<grpc.http_listen_port+1>.")
+	cfg.Sharding.RegisterFlags(f)
+}
+
+// tlsConfigName is the name under which the custom *tls.Config is registered
+// with the mysql driver. The name is fixed, so every server in the process
+// shares it and the most recent registerTLSConfig call replaces earlier ones.
+const tlsConfigName = "cortex-mysql-store"
+
+// registerTLSConfig loads the CA bundle from cfg.CAPath and registers it with
+// the go-sql-driver/mysql driver under tlsConfigName.
+func registerTLSConfig(cfg Config) error {
+	rootCertPool := x509.NewCertPool()
+	pem, err := ioutil.ReadFile(cfg.CAPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read mysql CA bundle")
+	}
+	if ok := rootCertPool.AppendCertsFromPEM(pem); !ok {
+		return errors.Errorf("failed to append mysql CA certs from %s", cfg.CAPath)
+	}
+
+	return mysql.RegisterTLSConfig(tlsConfigName, &tls.Config{
+		RootCAs:            rootCertPool,
+		InsecureSkipVerify: !cfg.HostVerification,
+	})
+}
+
+// buildDSNParams translates the SSL/timeout settings into go-sql-driver/mysql
+// DSN query parameters.
+func buildDSNParams(cfg Config) string {
+	params := ""
+	if cfg.SSL {
+		params += "&tls=" + tlsConfigName
+	}
+	if cfg.Timeout > 0 {
+		params += "&timeout=" + cfg.Timeout.String()
+	}
+	if cfg.ConnectTimeout > 0 {
+		params += "&readTimeout=" + cfg.ConnectTimeout.String()
+		params += "&writeTimeout=" + cfg.ConnectTimeout.String()
+	}
+	if len(params) > 0 {
+		params = "?" + params[1:]
+	}
+	return params
 }
 
 type server struct {
@@ -52,6 +116,11 @@ type server struct {
 	SchemaCfg chunk.SchemaConfig `yaml:"schema_cfg,omitempty"`
 	Session   *sql.DB            `yaml:"-"`
 	Logger    *zap.Logger
+
+	tableWhitelist tableWhitelist
+	shards         []shard
+	replicas       []*replica
+	replicaRR      uint64
 }
 
 // NewStorageClient returns a new StorageClient.
@@ -68,14 +137,35 @@ func NewStorageClient(cfg Config, schemaCfg chunk.SchemaConfig) (*server, error)
 		return nil, errors.WithStack(err)
 	}
 
+	if err := client.EnsureSchemaTables(context.Background()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	go client.WatchSchemaTables(context.Background())
+
+	if err := client.StartMetricsServer(client.defaultMetricsAddr()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	return client, nil
 }
 
 func (s *server) session() error {
+	if s.Cfg.SSL {
+		if err := registerTLSConfig(s.Cfg); err != nil {
+			s.Logger.Error("failed to register mysql tls config", zap.Error(err))
+			return err
+		}
+	}
+
+	if s.Cfg.Sharding.Enabled {
+		return s.openShards()
+	}
+
 	dataSourceName := s.Cfg.Username + ":" + s.Cfg.Password + "@tcp(" + s.Cfg.Addresses + ":" + strconv.Itoa(s.Cfg.Port) + ")/"
+	dsnParams := buildDSNParams(s.Cfg)
 
 	// initialise the conn with mysql-store
-	db, err := sql.Open("mysql", dataSourceName)
+	db, err := sql.Open("mysql", dataSourceName+dsnParams)
 	if err != nil {
 		s.Logger.Error("failed to establish connection with mysql", zap.Error(err))
 		return err
@@ -89,7 +179,7 @@ func (s *server) session() error {
 	}
 
 	// override the previous mysql-store connection with db connection
-	db, err = sql.Open("mysql", dataSourceName+s.Cfg.Database)
+	db, err = sql.Open("mysql", dataSourceName+s.Cfg.Database+dsnParams)
 	if err != nil {
 		s.Logger.Error("failed to establish connection with mysql database ", zap.Error(err))
 		return err
@@ -109,41 +199,100 @@ func (s *server) session() error {
 		return err
 	}
 
+	db.SetMaxOpenConns(s.Cfg.MaxOpenConns)
+	db.SetMaxIdleConns(s.Cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(s.Cfg.ConnMaxLifetime)
+
 	s.Session = db
 
+	if s.Cfg.ReadAddresses != "" {
+		if err := s.openReplicas(); err != nil {
+			s.Logger.Error("failed to connect to mysql read replicas", zap.Error(err))
+			return err
+		}
+	}
+
 	s.Logger.Info("mysql connected")
 	return nil
 }
 
 // PutChunks implements chunk.ObjectClient.
 func (s *server) PutChunks(ctx context.Context, chunks *grpc.PutChunksRequest) (*empty.Empty, error) {
+	ctx, span := startSpan(ctx, "PutChunks", "INSERT", "")
+	var err error
+	defer func() { endSpan(span, len(chunks.Chunks), err) }()
+
+	byDBTable := make(map[*sql.DB]map[string][]batchRow)
 	for _, chunkInfo := range chunks.Chunks {
-		s.Logger.Info("performing put chunks.", zap.String("table name", chunkInfo.TableName))
-		_, err := s.Session.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (hash, range01, value) VALUES (?, 0x00, ?) ON DUPLICATE KEY UPDATE value=VALUES(value)",
-			chunkInfo.TableName), chunkInfo.Key, chunkInfo.Encoded)
-		if err != nil {
-			s.Logger.Error("failed to put chunks %s", zap.Error(err))
-			return &empty.Empty{}, errors.WithStack(err)
+		row := batchRow{
+			Hash:    []byte(chunkInfo.Key),
+			Range01: []byte{0x00},
+			Value:   chunkInfo.Encoded,
+		}
+		for _, db := range s.dbsFor(row.Hash) {
+			if byDBTable[db] == nil {
+				byDBTable[db] = make(map[string][]batchRow)
+			}
+			byDBTable[db][chunkInfo.TableName] = append(byDBTable[db][chunkInfo.TableName], row)
 		}
 	}
+
+	s.Logger.Info("performing put chunks.", zap.Int("chunks", len(chunks.Chunks)))
+	if err = s.batchInsertGrouped(ctx, byDBTable); err != nil {
+		s.Logger.Error("failed to put chunks", zap.Error(err))
+		return &empty.Empty{}, err
+	}
+	rowsWrittenTotal.Add(float64(len(chunks.Chunks)))
 	return &empty.Empty{}, nil
 }
 
+// DeleteChunks implements chunk.ObjectClient by routing through the same
+// batched, transactional delete used by DeleteIndex.
 func (s *server) DeleteChunks(ctx context.Context, chunkID *grpc.ChunkID) (*empty.Empty, error) {
-	return &empty.Empty{}, chunk.ErrNotSupported
+	ctx, span := startSpan(ctx, "DeleteChunks", "DELETE", chunkID.TableName)
+	var err error
+	defer func() { endSpan(span, 1, err) }()
+
+	row := batchRow{Hash: []byte(chunkID.Key), Range01: []byte{0x00}}
+	s.Logger.Info("performing delete chunks.", zap.String("table name", chunkID.TableName))
+	for _, db := range s.dbsFor(row.Hash) {
+		if err = s.batchDelete(ctx, db, chunkID.TableName, []batchRow{row}); err != nil {
+			s.Logger.Error("failed to delete chunks", zap.Error(err))
+			return &empty.Empty{}, err
+		}
+	}
+	rowsDeletedTotal.Inc()
+	return &empty.Empty{}, nil
 }
 
 func (s *server) GetChunks(input *grpc.GetChunksRequest, chunksStreamer grpc.GrpcStore_GetChunksServer) error {
 	s.Logger.Info("performing get chunks.")
+	ctx, span := startSpan(chunksStreamer.Context(), "GetChunks", "SELECT", "")
 	var (
-		chunks []*grpc.Chunk
-		size   int
-		err    error
+		chunks   []*grpc.Chunk
+		size     int
+		err      error
+		rowsSent int
 	)
+	defer func() { endSpan(span, rowsSent, err) }()
 	for _, chunkData := range input.Chunks {
-		rows, err := s.Session.QueryContext(context.Background(), fmt.Sprintf("SELECT value FROM %s WHERE hash = ?", chunkData.TableName), chunkData.Key)
+		var ok bool
+		ok, err = s.allowedTable(ctx, chunkData.TableName)
+		if err != nil {
+			s.Logger.Error("failed to validate table name", zap.Error(err))
+			continue
+		}
+		if !ok {
+			err = errors.Errorf("unknown table %q", chunkData.TableName)
+			s.Logger.Error("rejected get chunks for unknown table", zap.String("table", chunkData.TableName))
+			continue
+		}
+
+		var rows *sql.Rows
+		rows, err = s.readDBFor([]byte(chunkData.Key)).QueryContext(ctx, fmt.Sprintf("SELECT value FROM %s WHERE hash = ?", chunkData.TableName), chunkData.Key)
 		if err != nil {
 			s.Logger.Error("failed to do get chunks ", zap.Error(err))
+			continue
 		}
 		for rows.Next() {
 			chk := &grpc.Chunk{}
@@ -168,6 +317,7 @@ func (s *server) GetChunks(input *grpc.GetChunksRequest, chunksStreamer grpc.Grp
 					}
 				}
 				s.Logger.Info("send peer chunks", zap.Int("len", len(chunks)))
+				responseSizeBytes.Observe(float64(size))
 				if err = chunksStreamer.Send(
 					&grpc.GetChunksResponse{
 						Chunks: chunks,
@@ -176,6 +326,8 @@ func (s *server) GetChunks(input *grpc.GetChunksRequest, chunksStreamer grpc.Grp
 					s.Logger.Error("Unable to stream the peer results", zap.Error(err))
 					return err
 				}
+				rowsSent += len(chunks)
+				rowsReadTotal.Add(float64(len(chunks)))
 				chunks = nil
 				size = 0
 				if last != nil {
@@ -191,6 +343,7 @@ func (s *server) GetChunks(input *grpc.GetChunksRequest, chunksStreamer grpc.Grp
 			s.Logger.Warn("response is too large")
 		}
 		s.Logger.Info("send chunks", zap.Int("len", len(chunks)))
+		responseSizeBytes.Observe(float64(size))
 		if err = chunksStreamer.Send(
 			&grpc.GetChunksResponse{
 				Chunks: chunks,
@@ -199,11 +352,21 @@ func (s *server) GetChunks(input *grpc.GetChunksRequest, chunksStreamer grpc.Grp
 			s.Logger.Error("Unable to stream the results", zap.Error(err))
 			return err
 		}
+		rowsSent += len(chunks)
+		rowsReadTotal.Add(float64(len(chunks)))
 	}
 	return nil
 }
 
+// Stop closes every connection pool this server holds open: the primary
+// session, every shard and every read replica, not just s.Session (which,
+// once sharding is enabled, is only shards[0]'s pool).
 func (s *server) Stop(context.Context, *empty.Empty) (*empty.Empty, error) {
-	err := s.Session.Close()
-	return &empty.Empty{}, err
+	var firstErr error
+	for _, db := range s.allPools() {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return &empty.Empty{}, firstErr
 }
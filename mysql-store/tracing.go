@@ -0,0 +1,33 @@
+package mysql_store
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("cortex-mysql-store")
+
+// startSpan opens a span for a single MySQL operation, tagged with the
+// statement and table being hit so traces from the Cortex querier carry
+// through into this storage layer.
+func startSpan(ctx context.Context, op, statement, table string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, op, trace.WithAttributes(
+		attribute.String("db.statement", statement),
+		attribute.String("db.mysql.table", table),
+	))
+}
+
+// endSpan records the row count and outcome of the operation started by
+// startSpan, then ends it.
+func endSpan(span trace.Span, rows int, err error) {
+	span.SetAttributes(attribute.Int("db.rows_affected", rows))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}